@@ -1,21 +1,28 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
 	"time"
 
 	"github.com/ebfe/scard"
+	"github.com/tpc3/go-felica/felica"
 	"github.com/tpc3/go-felica/felica_pcsc"
 )
 
 func main() {
-	ctx, err := scard.EstablishContext()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	pcscCtx, err := scard.EstablishContext()
 	if err != nil {
 		log.Panic("Failed to make context", err)
 	}
-	defer ctx.Release()
+	defer pcscCtx.Release()
 
-	readers, err := ctx.ListReaders()
+	readers, err := pcscCtx.ListReaders()
 	if err != nil {
 		log.Panic("Failed to list readers")
 	}
@@ -23,12 +30,13 @@ func main() {
 		log.Panic("Invalid number of reader: ", len(readers))
 	}
 
-	for {
+cardLoop:
+	for ctx.Err() == nil {
 		log.Print("Waiting for card...")
 
 		var rawCard *scard.Card
-		for rawCard == nil {
-			err := ctx.GetStatusChange([]scard.ReaderState{
+		for rawCard == nil && ctx.Err() == nil {
+			err := pcscCtx.GetStatusChange([]scard.ReaderState{
 				{
 					Reader:       readers[0],
 					CurrentState: scard.StateEmpty,
@@ -37,18 +45,24 @@ func main() {
 			if err != nil {
 				log.Panic("failed to wait card: ", err)
 			}
-			rawCard, err = ctx.Connect(readers[0], scard.ShareExclusive, scard.ProtocolT1)
+			rawCard, err = pcscCtx.Connect(readers[0], scard.ShareExclusive, scard.ProtocolT1)
 			if err != nil {
 				log.Print("failed to connect card: ", err)
 			}
 		}
+		if ctx.Err() != nil {
+			break
+		}
 
 		log.Print("card connected")
 
 		validCard := true
 
-		cardType, err := felica_pcsc.GetData(rawCard, felica_pcsc.DataTypeCardType)
+		cardType, err := felica_pcsc.GetDataContext(ctx, rawCard, felica.DataTypeCardType)
 		if err != nil {
+			if ctx.Err() != nil {
+				break cardLoop
+			}
 			log.Panic("Failed to get card type: ", err)
 		}
 		log.Printf("card type: %x", cardType)
@@ -56,8 +70,11 @@ func main() {
 			validCard = false
 		}
 
-		uid, err := felica_pcsc.GetData(rawCard, felica_pcsc.DataTypeUID)
+		uid, err := felica_pcsc.GetDataContext(ctx, rawCard, felica.DataTypeUID)
 		if err != nil {
+			if ctx.Err() != nil {
+				break cardLoop
+			}
 			log.Panic("Failed to get uid: ", err)
 		}
 		log.Printf("card uid: %x", uid)
@@ -68,7 +85,7 @@ func main() {
 		if validCard {
 			masterKey := [24]byte([]byte("xNhAMv2J4bAW86Nddq8WDizc"))
 
-			_, err = felica_pcsc.NewCard(rawCard, func(CKV [2]byte) *[24]byte {
+			_, err = felica_pcsc.NewCardContext(ctx, rawCard, func(CKV [2]byte) *[24]byte {
 				if CKV[0] == 0x00 && CKV[1] == 0x00 {
 					return &masterKey
 				}
@@ -81,7 +98,7 @@ func main() {
 			}
 		}
 
-		err = ctx.GetStatusChange([]scard.ReaderState{
+		err = pcscCtx.GetStatusChange([]scard.ReaderState{
 			{
 				Reader:       readers[0],
 				CurrentState: scard.StatePresent,
@@ -98,4 +115,6 @@ func main() {
 
 		log.Print("end")
 	}
+
+	log.Print("shutting down")
 }
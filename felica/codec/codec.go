@@ -0,0 +1,257 @@
+// Package codec marshals and unmarshals the 16-byte block payloads
+// FeliCa services store their records in, so callers don't have to
+// hand-parse a raw [16]byte for every read. Implementations are written
+// by hand against fixed offsets rather than reflection, so encode/decode
+// stays allocation-light on hot paths.
+package codec
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/tpc3/go-felica/felica"
+)
+
+// BlockMarshaler encodes a value into a single 16-byte block payload.
+type BlockMarshaler interface {
+	MarshalBlock() ([16]byte, error)
+}
+
+// BlockUnmarshaler decodes a single 16-byte block payload into a value.
+type BlockUnmarshaler interface {
+	UnmarshalBlock(data [16]byte) error
+}
+
+// ReadTyped reads the block at addr and unmarshals it into v.
+func ReadTyped(c *felica.FeliCaLiteS, addr byte, v BlockUnmarshaler) error {
+	blocks, err := c.Read([]byte{addr})
+	if err != nil {
+		return err
+	}
+	return v.UnmarshalBlock(blocks[0].Data)
+}
+
+func ReadTypedContext(ctx context.Context, c *felica.FeliCaLiteS, addr byte, v BlockUnmarshaler) error {
+	blocks, err := c.ReadContext(ctx, []byte{addr})
+	if err != nil {
+		return err
+	}
+	return v.UnmarshalBlock(blocks[0].Data)
+}
+
+// WriteTypedWithMac marshals v and writes it to addr, authenticated with
+// the session MAC (see FeliCaLiteS.WriteWithMac).
+func WriteTypedWithMac(c *felica.FeliCaLiteS, addr byte, v BlockMarshaler) error {
+	data, err := v.MarshalBlock()
+	if err != nil {
+		return err
+	}
+	return c.WriteWithMac(felica.Block{Address: addr, Data: data})
+}
+
+func WriteTypedWithMacContext(ctx context.Context, c *felica.FeliCaLiteS, addr byte, v BlockMarshaler) error {
+	data, err := v.MarshalBlock()
+	if err != nil {
+		return err
+	}
+	return c.WriteWithMacContext(ctx, felica.Block{Address: addr, Data: data})
+}
+
+// TLV packs or unpacks one or more type-length-value entries into a
+// single 16-byte S_PAD block, for user-defined layouts that don't match
+// one of the fixed record formats below.
+type TLV struct {
+	Entries []TLVEntry
+}
+
+type TLVEntry struct {
+	Type  byte
+	Value []byte
+}
+
+func (t *TLV) MarshalBlock() ([16]byte, error) {
+	var b [16]byte
+	off := 0
+	for _, e := range t.Entries {
+		n := len(e.Value)
+		if off+2+n > len(b) {
+			return b, fmt.Errorf("codec: TLV entries do not fit in a 16-byte block")
+		}
+		b[off] = e.Type
+		b[off+1] = byte(n)
+		copy(b[off+2:off+2+n], e.Value)
+		off += 2 + n
+	}
+	return b, nil
+}
+
+func (t *TLV) UnmarshalBlock(data [16]byte) error {
+	t.Entries = t.Entries[:0]
+	off := 0
+	for off < len(data) {
+		typ := data[off]
+		if typ == 0x00 {
+			break // padding
+		}
+		if off+2 > len(data) {
+			break
+		}
+		n := int(data[off+1])
+		if off+2+n > len(data) {
+			return fmt.Errorf("codec: TLV length %d at offset %d overruns the block", n, off)
+		}
+		value := make([]byte, n)
+		copy(value, data[off+2:off+2+n])
+		t.Entries = append(t.Entries, TLVEntry{Type: typ, Value: value})
+		off += 2 + n
+	}
+	return nil
+}
+
+// LiteSPad is a raw passthrough S_PAD block for callers that manage
+// their own application-defined layout without going through TLV.
+type LiteSPad [16]byte
+
+func (p *LiteSPad) MarshalBlock() ([16]byte, error) {
+	return [16]byte(*p), nil
+}
+
+func (p *LiteSPad) UnmarshalBlock(data [16]byte) error {
+	*p = LiteSPad(data)
+	return nil
+}
+
+// SuicaHistoryRecord decodes one entry of a Suica/PASMO-compatible
+// transit history service (system code 0x0003, service 0x090F).
+// EntryLineCode and ExitLineCode are station/line codes whose exact
+// interpretation depends on the operator, so they are exposed raw.
+type SuicaHistoryRecord struct {
+	ConsoleType   byte
+	ProcessType   byte
+	PaymentFlag   byte
+	PaymentType   byte
+	Date          uint16 // packed year/month/day, big-endian on the wire
+	EntryLineCode uint16 // big-endian; meaning depends on ProcessType
+	ExitLineCode  uint16 // big-endian; meaning depends on ProcessType
+	Balance       uint32 // little-endian 3-byte yen balance after this entry
+	SequenceNo    uint32 // big-endian 3-byte sequence number
+}
+
+func (r *SuicaHistoryRecord) MarshalBlock() ([16]byte, error) {
+	var b [16]byte
+	b[0] = r.ConsoleType
+	b[1] = r.ProcessType
+	b[2] = r.PaymentFlag
+	b[3] = r.PaymentType
+	binary.BigEndian.PutUint16(b[4:6], r.Date)
+	binary.BigEndian.PutUint16(b[6:8], r.EntryLineCode)
+	binary.BigEndian.PutUint16(b[8:10], r.ExitLineCode)
+	if r.Balance > 0xFFFFFF {
+		return b, fmt.Errorf("codec: balance %d overflows 3 bytes", r.Balance)
+	}
+	putUint24LE(b[10:13], r.Balance)
+	if r.SequenceNo > 0xFFFFFF {
+		return b, fmt.Errorf("codec: sequence number %d overflows 3 bytes", r.SequenceNo)
+	}
+	putUint24BE(b[13:16], r.SequenceNo)
+	return b, nil
+}
+
+func (r *SuicaHistoryRecord) UnmarshalBlock(b [16]byte) error {
+	r.ConsoleType = b[0]
+	r.ProcessType = b[1]
+	r.PaymentFlag = b[2]
+	r.PaymentType = b[3]
+	r.Date = binary.BigEndian.Uint16(b[4:6])
+	r.EntryLineCode = binary.BigEndian.Uint16(b[6:8])
+	r.ExitLineCode = binary.BigEndian.Uint16(b[8:10])
+	r.Balance = uint24LE(b[10:13])
+	r.SequenceNo = uint24BE(b[13:16])
+	return nil
+}
+
+// EdyTransactionRecord decodes one entry of an Edy e-money transaction
+// log (service 0x170F).
+type EdyTransactionRecord struct {
+	Type       byte
+	SequenceNo uint32 // big-endian 3-byte sequence number
+	Timestamp  uint32 // big-endian, Edy's packed date/time
+	Amount     int32  // big-endian; negative for a charge reversal
+	Balance    uint32 // big-endian yen balance after this transaction
+}
+
+func (r *EdyTransactionRecord) MarshalBlock() ([16]byte, error) {
+	var b [16]byte
+	b[0] = r.Type
+	if r.SequenceNo > 0xFFFFFF {
+		return b, fmt.Errorf("codec: sequence number %d overflows 3 bytes", r.SequenceNo)
+	}
+	putUint24BE(b[1:4], r.SequenceNo)
+	binary.BigEndian.PutUint32(b[4:8], r.Timestamp)
+	binary.BigEndian.PutUint32(b[8:12], uint32(r.Amount))
+	binary.BigEndian.PutUint32(b[12:16], r.Balance)
+	return b, nil
+}
+
+func (r *EdyTransactionRecord) UnmarshalBlock(b [16]byte) error {
+	r.Type = b[0]
+	r.SequenceNo = uint24BE(b[1:4])
+	r.Timestamp = binary.BigEndian.Uint32(b[4:8])
+	r.Amount = int32(binary.BigEndian.Uint32(b[8:12]))
+	r.Balance = binary.BigEndian.Uint32(b[12:16])
+	return nil
+}
+
+// NanacoLog decodes one entry of a nanaco point/e-money log, which
+// follows the same big-endian sequence/date/amount/balance shape common
+// to FeliCa e-money services.
+type NanacoLog struct {
+	ProcessType byte
+	SequenceNo  uint32 // big-endian 3-byte sequence number
+	Date        uint32 // big-endian packed date/time
+	Amount      int32
+	Balance     uint32
+}
+
+func (r *NanacoLog) MarshalBlock() ([16]byte, error) {
+	var b [16]byte
+	b[0] = r.ProcessType
+	if r.SequenceNo > 0xFFFFFF {
+		return b, fmt.Errorf("codec: sequence number %d overflows 3 bytes", r.SequenceNo)
+	}
+	putUint24BE(b[1:4], r.SequenceNo)
+	binary.BigEndian.PutUint32(b[4:8], r.Date)
+	binary.BigEndian.PutUint32(b[8:12], uint32(r.Amount))
+	binary.BigEndian.PutUint32(b[12:16], r.Balance)
+	return b, nil
+}
+
+func (r *NanacoLog) UnmarshalBlock(b [16]byte) error {
+	r.ProcessType = b[0]
+	r.SequenceNo = uint24BE(b[1:4])
+	r.Date = binary.BigEndian.Uint32(b[4:8])
+	r.Amount = int32(binary.BigEndian.Uint32(b[8:12]))
+	r.Balance = binary.BigEndian.Uint32(b[12:16])
+	return nil
+}
+
+func putUint24BE(b []byte, v uint32) {
+	b[0] = byte(v >> 16)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v)
+}
+
+func uint24BE(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+func putUint24LE(b []byte, v uint32) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
+
+func uint24LE(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
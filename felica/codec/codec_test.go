@@ -0,0 +1,204 @@
+package codec
+
+import "testing"
+
+func TestSuicaHistoryRecordRoundTrip(t *testing.T) {
+	want := SuicaHistoryRecord{
+		ConsoleType:   0x03,
+		ProcessType:   0x16,
+		PaymentFlag:   0x00,
+		PaymentType:   0x00,
+		Date:          0x4A21,
+		EntryLineCode: 0x0101,
+		ExitLineCode:  0x0205,
+		Balance:       12345,
+		SequenceNo:    98765,
+	}
+	data, err := want.MarshalBlock()
+	if err != nil {
+		t.Fatalf("MarshalBlock: %v", err)
+	}
+	var got SuicaHistoryRecord
+	if err := got.UnmarshalBlock(data); err != nil {
+		t.Fatalf("UnmarshalBlock: %v", err)
+	}
+	if got != want {
+		t.Errorf("round trip mismatch: got %+v, want %+v", got, want)
+	}
+}
+
+func TestSuicaHistoryRecordOverflow(t *testing.T) {
+	if _, err := (&SuicaHistoryRecord{Balance: 0x1000000}).MarshalBlock(); err == nil {
+		t.Error("expected an error for a balance overflowing 3 bytes")
+	}
+	if _, err := (&SuicaHistoryRecord{SequenceNo: 0x1000000}).MarshalBlock(); err == nil {
+		t.Error("expected an error for a sequence number overflowing 3 bytes")
+	}
+}
+
+func TestEdyTransactionRecordRoundTrip(t *testing.T) {
+	want := EdyTransactionRecord{
+		Type:       0x20,
+		SequenceNo: 4242,
+		Timestamp:  0x1E2A3B4C,
+		Amount:     -500,
+		Balance:    9800,
+	}
+	data, err := want.MarshalBlock()
+	if err != nil {
+		t.Fatalf("MarshalBlock: %v", err)
+	}
+	var got EdyTransactionRecord
+	if err := got.UnmarshalBlock(data); err != nil {
+		t.Fatalf("UnmarshalBlock: %v", err)
+	}
+	if got != want {
+		t.Errorf("round trip mismatch: got %+v, want %+v", got, want)
+	}
+}
+
+func TestEdyTransactionRecordOverflow(t *testing.T) {
+	if _, err := (&EdyTransactionRecord{SequenceNo: 0x1000000}).MarshalBlock(); err == nil {
+		t.Error("expected an error for a sequence number overflowing 3 bytes")
+	}
+}
+
+func TestNanacoLogRoundTrip(t *testing.T) {
+	want := NanacoLog{
+		ProcessType: 0x01,
+		SequenceNo:  777,
+		Date:        0x2A3B4C5D,
+		Amount:      1500,
+		Balance:     30000,
+	}
+	data, err := want.MarshalBlock()
+	if err != nil {
+		t.Fatalf("MarshalBlock: %v", err)
+	}
+	var got NanacoLog
+	if err := got.UnmarshalBlock(data); err != nil {
+		t.Fatalf("UnmarshalBlock: %v", err)
+	}
+	if got != want {
+		t.Errorf("round trip mismatch: got %+v, want %+v", got, want)
+	}
+}
+
+func TestNanacoLogOverflow(t *testing.T) {
+	if _, err := (&NanacoLog{SequenceNo: 0x1000000}).MarshalBlock(); err == nil {
+		t.Error("expected an error for a sequence number overflowing 3 bytes")
+	}
+}
+
+func TestTLVRoundTrip(t *testing.T) {
+	want := TLV{Entries: []TLVEntry{
+		{Type: 0x01, Value: []byte{0xDE, 0xAD}},
+		{Type: 0x02, Value: []byte{0x01, 0x02, 0x03, 0x04}},
+	}}
+	data, err := want.MarshalBlock()
+	if err != nil {
+		t.Fatalf("MarshalBlock: %v", err)
+	}
+	var got TLV
+	if err := got.UnmarshalBlock(data); err != nil {
+		t.Fatalf("UnmarshalBlock: %v", err)
+	}
+	if len(got.Entries) != len(want.Entries) {
+		t.Fatalf("entry count mismatch: got %d, want %d", len(got.Entries), len(want.Entries))
+	}
+	for i := range want.Entries {
+		if got.Entries[i].Type != want.Entries[i].Type || string(got.Entries[i].Value) != string(want.Entries[i].Value) {
+			t.Errorf("entry %d mismatch: got %+v, want %+v", i, got.Entries[i], want.Entries[i])
+		}
+	}
+}
+
+func TestTLVOverflow(t *testing.T) {
+	t1 := TLV{Entries: []TLVEntry{{Type: 0x01, Value: make([]byte, 15)}}}
+	if _, err := t1.MarshalBlock(); err == nil {
+		t.Error("expected an error when entries do not fit in a 16-byte block")
+	}
+}
+
+func BenchmarkSuicaHistoryRecordRoundTrip(b *testing.B) {
+	rec := SuicaHistoryRecord{
+		ConsoleType:   0x03,
+		ProcessType:   0x16,
+		Date:          0x4A21,
+		EntryLineCode: 0x0101,
+		ExitLineCode:  0x0205,
+		Balance:       12345,
+		SequenceNo:    98765,
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data, err := rec.MarshalBlock()
+		if err != nil {
+			b.Fatal(err)
+		}
+		var out SuicaHistoryRecord
+		if err := out.UnmarshalBlock(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEdyTransactionRecordRoundTrip(b *testing.B) {
+	rec := EdyTransactionRecord{
+		Type:       0x20,
+		SequenceNo: 4242,
+		Timestamp:  0x1E2A3B4C,
+		Amount:     -500,
+		Balance:    9800,
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data, err := rec.MarshalBlock()
+		if err != nil {
+			b.Fatal(err)
+		}
+		var out EdyTransactionRecord
+		if err := out.UnmarshalBlock(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNanacoLogRoundTrip(b *testing.B) {
+	rec := NanacoLog{
+		ProcessType: 0x01,
+		SequenceNo:  777,
+		Date:        0x2A3B4C5D,
+		Amount:      1500,
+		Balance:     30000,
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data, err := rec.MarshalBlock()
+		if err != nil {
+			b.Fatal(err)
+		}
+		var out NanacoLog
+		if err := out.UnmarshalBlock(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTLVRoundTrip(b *testing.B) {
+	rec := TLV{Entries: []TLVEntry{
+		{Type: 0x01, Value: []byte{0xDE, 0xAD}},
+		{Type: 0x02, Value: []byte{0x01, 0x02, 0x03, 0x04}},
+	}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data, err := rec.MarshalBlock()
+		if err != nil {
+			b.Fatal(err)
+		}
+		var out TLV
+		if err := out.UnmarshalBlock(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
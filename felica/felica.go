@@ -1,9 +1,16 @@
 package felica
 
 import (
+	"bytes"
+	"context"
 	"crypto/des"
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"log"
+	mathrand "math/rand"
+	"time"
 )
 
 var ErrNoResponse = errors.New("no response from card")
@@ -11,11 +18,122 @@ var ErrUnknown = errors.New("unknown error")
 var ErrMasterKeyNil = errors.New("master key is nil")
 var ErrMacNotMatched = errors.New("mac_a didn't matched")
 
+// Transport is the link FeliCaLiteS uses to exchange APDUs with a card. It
+// is deliberately small so that any reader can implement it: felica_pcsc
+// wraps a *scard.Card, but an in-memory fake or a native FeliCa frame
+// reader works just as well.
+type Transport interface {
+	Transmit(apdu []byte) ([]byte, error)
+}
+
+// Canceler is an optional Transport capability. When a ...Context
+// operation's context is cancelled while a Transmit call is in flight,
+// Cancel is invoked from a separate goroutine to unblock the underlying
+// reader. Transports that don't implement it simply run the in-flight
+// call to completion, and the Context call returns ctx's error only once
+// that Transmit has returned.
+type Canceler interface {
+	Cancel() error
+}
+
 type FeliCaLiteS struct {
-	CK [16]byte
-	SK [16]byte
-	RC [16]byte
-	ID [16]byte
+	Transport Transport
+	// RetryBackoff, if set, is consulted whenever a transmission gets
+	// ErrNoResponse or a Transport-level error: it returns how long to
+	// wait before retrying, or a non-positive duration to give up. Left
+	// nil, no retries happen. See DefaultRetryBackoff.
+	RetryBackoff RetryBackoff
+	CK           [16]byte
+	SK           [16]byte
+	RC           [16]byte
+	ID           [16]byte
+}
+
+// RetryBackoff decides how long to wait before retrying attempt (0 for
+// the first retry) after lastErr and lastResp from the previous attempt.
+// A non-positive duration means give up and return that attempt's result.
+type RetryBackoff func(attempt int, lastErr error, lastResp []byte) time.Duration
+
+// DefaultRetryBackoff is a truncated exponential backoff starting at
+// 100ms, capped at 2s, with up to 200ms of random jitter added on top.
+// It gives up after 5 retries.
+func DefaultRetryBackoff(attempt int, lastErr error, lastResp []byte) time.Duration {
+	if attempt >= 5 {
+		return 0
+	}
+	base := 100 * time.Millisecond * time.Duration(1<<attempt)
+	if base > 2*time.Second {
+		base = 2 * time.Second
+	}
+	return base + time.Duration(mathrand.Intn(200))*time.Millisecond
+}
+
+// isNoResponse reports whether resp is the "no response from card"
+// pseudo-APDU trailer (SW 64 01).
+func isNoResponse(resp []byte) bool {
+	return len(resp) >= 2 && resp[len(resp)-2] == 0x64 && resp[len(resp)-1] == 0x01
+}
+
+// transmitOnce runs a single Transmit call on a goroutine so that a ctx
+// cancellation can be noticed while the call is still blocked in the
+// transport. If ctx is done first, it asks the transport to cancel (when
+// it implements Canceler) but still waits for the goroutine to return
+// rather than leaking it.
+func transmitOnce(ctx context.Context, transport Transport, apdu []byte) ([]byte, error) {
+	type result struct {
+		resp []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := transport.Transmit(apdu)
+		done <- result{resp, err}
+	}()
+	select {
+	case r := <-done:
+		return r.resp, r.err
+	case <-ctx.Done():
+		if canceler, ok := transport.(Canceler); ok {
+			canceler.Cancel()
+		}
+		<-done
+		return nil, ctx.Err()
+	}
+}
+
+// transmit sends apdu through transport, retrying on ErrNoResponse and
+// transport-level errors according to backoff until it succeeds, backoff
+// gives up, or ctx is done. It never retries once a response has been
+// returned and parsed by the caller (e.g. a MAC mismatch), since only the
+// raw transmission is retried here.
+func transmit(ctx context.Context, transport Transport, backoff RetryBackoff, apdu []byte) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		resp, err := transmitOnce(ctx, transport, apdu)
+		if err == nil && !isNoResponse(resp) {
+			return resp, nil
+		}
+		if backoff == nil {
+			return resp, err
+		}
+		wait := backoff(attempt, err, resp)
+		if wait <= 0 {
+			return resp, err
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *FeliCaLiteS) transmit(ctx context.Context, apdu []byte) ([]byte, error) {
+	return transmit(ctx, c.Transport, c.RetryBackoff, apdu)
 }
 
 type Block struct {
@@ -69,8 +187,231 @@ const (
 	ServiceRO = 0x000b
 )
 
+func GetData(transport Transport, backoff RetryBackoff, dataType DataType) ([]byte, error) {
+	return GetDataContext(context.Background(), transport, backoff, dataType)
+}
+
+func GetDataContext(ctx context.Context, transport Transport, backoff RetryBackoff, dataType DataType) ([]byte, error) {
+	command := []byte{0xFF, 0xCA, byte(dataType), 0x00, 0x00}
+	resp, err := transmit(ctx, transport, backoff, command)
+	if err != nil {
+		return nil, err
+	}
+	if resp[len(resp)-2] == 0x90 && resp[len(resp)-1] == 0x00 {
+		return resp[:len(resp)-2], nil
+	} else if resp[len(resp)-2] == 0x64 && resp[len(resp)-1] == 0x01 {
+		return nil, ErrNoResponse
+	} else {
+		return resp, fmt.Errorf("%w: %x", ErrUnknown, resp)
+	}
+}
+
+// Return MasterKey from CKV
+// returning nil results ErrMasterKeyNil
+type MasterKeyProvider func([2]byte) *[24]byte
+
+// If masterKeyProvider is nil, MAC check skipped. The returned card's
+// RetryBackoff defaults to DefaultRetryBackoff; set it to nil to disable
+// retries. Note that retries happen per Transmit, not around this whole
+// handshake: a failed leg is resent as-is, which is safe because the RC
+// written earlier in the handshake is never regenerated mid-retry.
+func NewFelicaCard(transport Transport, masterKeyProvider MasterKeyProvider) (*FeliCaLiteS, error) {
+	return NewFelicaCardContext(context.Background(), transport, masterKeyProvider)
+}
+
+func NewFelicaCardContext(ctx context.Context, transport Transport, masterKeyProvider MasterKeyProvider) (*FeliCaLiteS, error) {
+	c := FeliCaLiteS{
+		Transport:    transport,
+		RetryBackoff: DefaultRetryBackoff,
+	}
+
+	err := c.SetServiceContext(ctx, ServiceRW)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set service: %w", err)
+	}
+
+	_, err = rand.Read(c.RC[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate RC: %w", err)
+	}
+
+	err = c.WriteContext(ctx, []Block{{
+		Address: AddressRC,
+		Data:    c.RC,
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write RC: %w", err)
+	}
+
+	resp, err := c.ReadContext(ctx, []byte{AddressID, AddressCKV, AddressMAC_A})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ID: %w", err)
+	}
+
+	c.ID = resp[0].Data
+
+	if masterKeyProvider != nil {
+		masterKey := masterKeyProvider(([2]byte)(resp[1].Data[:2]))
+
+		if masterKey == nil {
+			return &c, ErrMasterKeyNil
+		}
+
+		c.GenCardKey(masterKey)
+
+		c.GenSessionKey()
+
+		mac := c.GenReadMac(resp)
+
+		if mac != [8]byte(resp[2].Data[:8]) {
+			return &c, ErrMacNotMatched
+		}
+	}
+
+	return &c, nil
+}
+
+func (c *FeliCaLiteS) Read(address []byte) ([]Block, error) {
+	return c.ReadContext(context.Background(), address)
+}
+
+func (c *FeliCaLiteS) ReadContext(ctx context.Context, address []byte) ([]Block, error) {
+	blockList := make([]byte, 0, len(address)*2)
+	for _, v := range address {
+		blockList = append(blockList, 0x80)
+		blockList = append(blockList, v)
+	}
+	command := []byte{0xFF, 0xB0, 0x80, byte(len(address)), byte(len(blockList))}
+	command = append(command, blockList...)
+	command = append(command, 0x00)
+	resp, err := c.transmit(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	if resp[len(resp)-2] == 0x90 && resp[len(resp)-1] == 0x00 {
+		res := make([]Block, 0, len(address))
+		for i := 0; i < len(address); i++ {
+			res = append(res, Block{
+				Address: address[i],
+				Data:    ([16]byte)(resp[i*16 : (i+1)*16]),
+			})
+		}
+		return res, nil
+	} else if resp[len(resp)-2] == 0x64 && resp[len(resp)-1] == 0x01 {
+		return nil, ErrNoResponse
+	} else {
+		return nil, fmt.Errorf("%w: %x", ErrUnknown, resp)
+	}
+}
+
+// address length: 1-3
+func (c *FeliCaLiteS) ReadWithMac(address []byte) ([]Block, error) {
+	return c.ReadWithMacContext(context.Background(), address)
+}
+
+// address length: 1-3
+func (c *FeliCaLiteS) ReadWithMacContext(ctx context.Context, address []byte) ([]Block, error) {
+	resp, err := c.ReadContext(ctx, append(address, AddressMAC_A))
+	if err != nil {
+		return nil, err
+	}
+	mac := c.GenReadMac(resp)
+	if bytes.Equal(mac[:], resp[len(resp)-1].Data[:8]) {
+		return resp, nil
+	} else {
+		return resp, ErrMacNotMatched
+	}
+}
+
+func (c *FeliCaLiteS) Write(data []Block) error {
+	return c.WriteContext(context.Background(), data)
+}
+
+func (c *FeliCaLiteS) WriteContext(ctx context.Context, data []Block) error {
+	blockList := make([]byte, 0, len(data)*2)
+	dataArr := make([]byte, 0, len(data)*16)
+	for _, v := range data {
+		blockList = append(blockList, 0x80)
+		blockList = append(blockList, v.Address)
+		for _, v := range v.Data {
+			dataArr = append(dataArr, v)
+		}
+	}
+	command := []byte{0xFF, 0xD6, 0x80, byte(len(data)), byte(len(blockList) + len(dataArr))}
+	command = append(command, blockList...)
+	command = append(command, dataArr...)
+	command = append(command, 0x00)
+	resp, err := c.transmit(ctx, command)
+	if err != nil {
+		return err
+	}
+	if resp[len(resp)-2] == 0x90 && resp[len(resp)-1] == 0x00 {
+		// success
+		return nil
+	} else if resp[len(resp)-2] == 0x64 && resp[len(resp)-1] == 0x01 {
+		return ErrNoResponse
+	} else {
+		return fmt.Errorf("%w: %x", ErrUnknown, resp)
+	}
+}
+
+func (c *FeliCaLiteS) WriteWithMac(data Block) error {
+	return c.WriteWithMacContext(context.Background(), data)
+}
+
+func (c *FeliCaLiteS) WriteWithMacContext(ctx context.Context, data Block) error {
+	resp, err := c.ReadWithMacContext(ctx, []byte{AddressWCNT})
+	if err != nil {
+		return err
+	}
+	macRaw := c.GenWriteMac([3]byte(resp[0].Data[:3]), data)
+	var macPadd [16]byte
+	copy(macPadd[:], macRaw[:])
+	return c.WriteContext(ctx, []Block{data, {
+		Address: AddressMAC_A,
+		Data:    macPadd,
+	}})
+}
+
+func (c *FeliCaLiteS) SetService(service uint16) error {
+	return c.SetServiceContext(context.Background(), service)
+}
+
+func (c *FeliCaLiteS) SetServiceContext(ctx context.Context, service uint16) error {
+	command := []byte{0xFF, 0xA4, 0x00, 0x01, 0x02}
+	command = binary.LittleEndian.AppendUint16(command, service)
+	resp, err := c.transmit(ctx, command)
+	if err != nil {
+		return err
+	}
+	if resp[len(resp)-2] == 0x90 && resp[len(resp)-1] == 0x00 {
+		// success
+		return nil
+	} else if resp[len(resp)-2] == 0x64 && resp[len(resp)-1] == 0x01 {
+		return ErrNoResponse
+	} else {
+		return fmt.Errorf("%w: %x", ErrUnknown, resp)
+	}
+}
+
+func (c *FeliCaLiteS) Command(command []byte) ([]byte, error) {
+	return c.CommandContext(context.Background(), command)
+}
+
+func (c *FeliCaLiteS) CommandContext(ctx context.Context, command []byte) ([]byte, error) {
+	base := []byte{0xFF, 0xFE, 0x00, 0x00, byte(len(command))}
+	return c.transmit(ctx, append(base, command...))
+}
+
 // Generate CK with ID
 func (c *FeliCaLiteS) GenCardKey(masterKey *[24]byte) {
+	c.CK = deriveCardKey(c.ID, masterKey)
+}
+
+// deriveCardKey is the pure ID+masterKey -> CK computation behind
+// GenCardKey, split out so RotateKey can compute a card's next CK
+// without disturbing the receiver's current CK/SK.
+func deriveCardKey(id [16]byte, masterKey *[24]byte) [16]byte {
 	cipher, err := des.NewTripleDESCipher(masterKey[:])
 	if err != nil {
 		log.Panic("Failed to make cipher")
@@ -87,9 +428,8 @@ func (c *FeliCaLiteS) GenCardKey(masterKey *[24]byte) {
 	if LMSB != 0 {
 		L[len(L)-1] = L[len(L)-1] ^ 0x1b
 	}
-	ID := c.ID
-	M1 := ID[:8]
-	M2 := ID[8:]
+	M1 := id[:8]
+	M2 := id[8:]
 	xor(M2, L)
 	C1 := make([]byte, des.BlockSize)
 	cipher.Encrypt(C1, M1)
@@ -101,7 +441,7 @@ func (c *FeliCaLiteS) GenCardKey(masterKey *[24]byte) {
 	T2 := make([]byte, des.BlockSize)
 	xor(C2, M2)
 	cipher.Encrypt(T2, C2)
-	c.CK = ([16]byte)(append(T1, T2...))
+	return ([16]byte)(append(T1, T2...))
 }
 
 // Generate SK with CK and RC
@@ -0,0 +1,320 @@
+// Package frame builds and parses native FeliCa command/response frames,
+// as opposed to the ACR122 pseudo-APDUs used elsewhere in this module.
+// It lets a Transport (see felica.Transport) talk to full FeliCa Standard
+// cards - Suica, Edy, nanaco and the like - in addition to Lite-S, as
+// long as the transport can move a raw frame to and from the card (on
+// PC/SC readers that means wrapping it in the ACR122 FF FE pass-through;
+// see felica_pcsc.Transport.Command).
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Command and response codes for the native FeliCa command set.
+const (
+	CmdPolling                 byte = 0x00
+	RespPolling                byte = 0x01
+	CmdRequestService          byte = 0x02
+	RespRequestService         byte = 0x03
+	CmdRequestResponse         byte = 0x04
+	RespRequestResponse        byte = 0x05
+	CmdReadWithoutEncryption   byte = 0x06
+	RespReadWithoutEncryption  byte = 0x07
+	CmdWriteWithoutEncryption  byte = 0x08
+	RespWriteWithoutEncryption byte = 0x09
+	CmdSearchServiceCode       byte = 0x0A
+	RespSearchServiceCode      byte = 0x0B
+	CmdRequestSystemCode       byte = 0x0C
+	RespRequestSystemCode      byte = 0x0D
+)
+
+var (
+	ErrShortFrame    = errors.New("frame: frame shorter than its LEN byte")
+	ErrUnexpectedCmd = errors.New("frame: unexpected response command code")
+)
+
+// StatusError reports a non-zero status flag pair returned in response to
+// Read/Write Without Encryption. Flag1 0x00 always means success; the
+// meaning of Flag2 beyond that is card-specific.
+type StatusError struct {
+	Flag1 byte
+	Flag2 byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("frame: status flag1=%#02x flag2=%#02x", e.Flag1, e.Flag2)
+}
+
+func checkStatus(flag1, flag2 byte) error {
+	if flag1 == 0x00 {
+		return nil
+	}
+	return &StatusError{Flag1: flag1, Flag2: flag2}
+}
+
+// checkFrame validates the LEN byte, the response command code, and that
+// at least minPayload bytes follow the 2-byte LEN+command header.
+func checkFrame(f []byte, wantCmd byte, minPayload int) error {
+	if len(f) < 2 || int(f[0]) != len(f) {
+		return ErrShortFrame
+	}
+	if f[1] != wantCmd {
+		return fmt.Errorf("%w: got %#02x want %#02x", ErrUnexpectedCmd, f[1], wantCmd)
+	}
+	if len(f) < 2+minPayload {
+		return ErrShortFrame
+	}
+	return nil
+}
+
+func finish(buf []byte) []byte {
+	buf[0] = byte(len(buf))
+	return buf
+}
+
+// BlockListElement encodes one entry of a Read/Write Without Encryption
+// block list. ServiceCodeOrder selects which entry of the accompanying
+// service code list the block belongs to (0 for a single-service call).
+type BlockListElement struct {
+	ServiceCodeOrder byte
+	AccessMode       byte
+	Block            uint16
+}
+
+// Encode returns the 2-byte block list element form when Block fits in a
+// byte, otherwise the 3-byte form.
+func (b BlockListElement) Encode() []byte {
+	if b.Block <= 0xFF {
+		return []byte{0x80 | b.AccessMode<<4 | b.ServiceCodeOrder&0x0F, byte(b.Block)}
+	}
+	return []byte{b.AccessMode<<4 | b.ServiceCodeOrder&0x0F, byte(b.Block), byte(b.Block >> 8)}
+}
+
+// BuildPolling builds a Polling (0x00) command frame. systemCode 0xFFFF
+// matches any system. requestCode selects what the response carries
+// beyond IDm/PMm (0x00 none, 0x01 system code, 0x02 communication
+// performance); timeSlot picks the number of response time slots.
+func BuildPolling(systemCode uint16, requestCode byte, timeSlot byte) []byte {
+	buf := []byte{0x00, CmdPolling, 0, 0, requestCode, timeSlot}
+	binary.BigEndian.PutUint16(buf[2:4], systemCode)
+	return finish(buf)
+}
+
+type PollingResponse struct {
+	IDm [8]byte
+	PMm [8]byte
+	// SystemCode is only populated when Polling was sent with
+	// requestCode 0x01 and the card included it.
+	SystemCode uint16
+}
+
+func ParsePolling(f []byte) (*PollingResponse, error) {
+	if err := checkFrame(f, RespPolling, 16); err != nil {
+		return nil, err
+	}
+	r := &PollingResponse{}
+	copy(r.IDm[:], f[2:10])
+	copy(r.PMm[:], f[10:18])
+	if len(f) >= 20 {
+		r.SystemCode = binary.BigEndian.Uint16(f[18:20])
+	}
+	return r, nil
+}
+
+// BuildRequestService builds a Request Service (0x02) command frame.
+func BuildRequestService(idm [8]byte, serviceCodes []uint16) []byte {
+	buf := append([]byte{0x00, CmdRequestService}, idm[:]...)
+	buf = append(buf, byte(len(serviceCodes)))
+	for _, sc := range serviceCodes {
+		buf = binary.LittleEndian.AppendUint16(buf, sc)
+	}
+	return finish(buf)
+}
+
+type RequestServiceResponse struct {
+	IDm [8]byte
+	// NodeKeyVersions is parallel to the requested service codes; 0xFFFF
+	// means the corresponding service code was not found on the card.
+	NodeKeyVersions []uint16
+}
+
+func ParseRequestService(f []byte) (*RequestServiceResponse, error) {
+	if err := checkFrame(f, RespRequestService, 9); err != nil {
+		return nil, err
+	}
+	r := &RequestServiceResponse{}
+	copy(r.IDm[:], f[2:10])
+	n := int(f[10])
+	r.NodeKeyVersions = make([]uint16, 0, n)
+	for i := 0; i < n; i++ {
+		off := 11 + i*2
+		if off+2 > len(f) {
+			return nil, ErrShortFrame
+		}
+		r.NodeKeyVersions = append(r.NodeKeyVersions, binary.LittleEndian.Uint16(f[off:off+2]))
+	}
+	return r, nil
+}
+
+// BuildRequestResponse builds a Request Response (0x04) command frame,
+// used to poll a card's mode without a full Polling exchange.
+func BuildRequestResponse(idm [8]byte) []byte {
+	return finish(append([]byte{0x00, CmdRequestResponse}, idm[:]...))
+}
+
+type RequestResponseResponse struct {
+	IDm    [8]byte
+	Status byte
+}
+
+func ParseRequestResponse(f []byte) (*RequestResponseResponse, error) {
+	if err := checkFrame(f, RespRequestResponse, 9); err != nil {
+		return nil, err
+	}
+	return &RequestResponseResponse{IDm: [8]byte(f[2:10]), Status: f[10]}, nil
+}
+
+// BuildReadWithoutEncryption builds a Read Without Encryption (0x06)
+// command frame for the given services and blocks.
+func BuildReadWithoutEncryption(idm [8]byte, serviceCodes []uint16, blocks []BlockListElement) []byte {
+	buf := append([]byte{0x00, CmdReadWithoutEncryption}, idm[:]...)
+	buf = append(buf, byte(len(serviceCodes)))
+	for _, sc := range serviceCodes {
+		buf = binary.LittleEndian.AppendUint16(buf, sc)
+	}
+	buf = append(buf, byte(len(blocks)))
+	for _, b := range blocks {
+		buf = append(buf, b.Encode()...)
+	}
+	return finish(buf)
+}
+
+type ReadWithoutEncryptionResponse struct {
+	IDm    [8]byte
+	Blocks [][16]byte
+}
+
+func ParseReadWithoutEncryption(f []byte) (*ReadWithoutEncryptionResponse, error) {
+	if err := checkFrame(f, RespReadWithoutEncryption, 10); err != nil {
+		return nil, err
+	}
+	idm := [8]byte(f[2:10])
+	if err := checkStatus(f[10], f[11]); err != nil {
+		return &ReadWithoutEncryptionResponse{IDm: idm}, err
+	}
+	if len(f) < 13 {
+		return nil, ErrShortFrame
+	}
+	n := int(f[12])
+	blocks := make([][16]byte, 0, n)
+	for i := 0; i < n; i++ {
+		off := 13 + i*16
+		if off+16 > len(f) {
+			return nil, ErrShortFrame
+		}
+		blocks = append(blocks, [16]byte(f[off:off+16]))
+	}
+	return &ReadWithoutEncryptionResponse{IDm: idm, Blocks: blocks}, nil
+}
+
+// BuildWriteWithoutEncryption builds a Write Without Encryption (0x08)
+// command frame. data must have the same length as blocks.
+func BuildWriteWithoutEncryption(idm [8]byte, serviceCodes []uint16, blocks []BlockListElement, data [][16]byte) []byte {
+	buf := append([]byte{0x00, CmdWriteWithoutEncryption}, idm[:]...)
+	buf = append(buf, byte(len(serviceCodes)))
+	for _, sc := range serviceCodes {
+		buf = binary.LittleEndian.AppendUint16(buf, sc)
+	}
+	buf = append(buf, byte(len(blocks)))
+	for _, b := range blocks {
+		buf = append(buf, b.Encode()...)
+	}
+	for _, d := range data {
+		buf = append(buf, d[:]...)
+	}
+	return finish(buf)
+}
+
+type WriteWithoutEncryptionResponse struct {
+	IDm [8]byte
+}
+
+func ParseWriteWithoutEncryption(f []byte) (*WriteWithoutEncryptionResponse, error) {
+	if err := checkFrame(f, RespWriteWithoutEncryption, 10); err != nil {
+		return nil, err
+	}
+	idm := [8]byte(f[2:10])
+	if err := checkStatus(f[10], f[11]); err != nil {
+		return &WriteWithoutEncryptionResponse{IDm: idm}, err
+	}
+	return &WriteWithoutEncryptionResponse{IDm: idm}, nil
+}
+
+// BuildSearchServiceCode builds a Search Service Code (0x0A) command
+// frame. Callers walk index from 0 until the response is empty to
+// enumerate every area and service code on a card.
+func BuildSearchServiceCode(idm [8]byte, index uint16) []byte {
+	buf := append([]byte{0x00, CmdSearchServiceCode}, idm[:]...)
+	buf = binary.LittleEndian.AppendUint16(buf, index)
+	return finish(buf)
+}
+
+// SearchServiceCodeResponse carries either a 2-byte area code or up to
+// two 2-byte service codes found at the requested index. Found is false
+// once index has walked past the last entry.
+type SearchServiceCodeResponse struct {
+	IDm   [8]byte
+	Codes []uint16
+	Found bool
+}
+
+func ParseSearchServiceCode(f []byte) (*SearchServiceCodeResponse, error) {
+	if err := checkFrame(f, RespSearchServiceCode, 10); err != nil {
+		return nil, err
+	}
+	r := &SearchServiceCodeResponse{}
+	copy(r.IDm[:], f[2:10])
+	payload := f[10:]
+	first := binary.LittleEndian.Uint16(payload[:2])
+	if first == 0xFFFF {
+		return r, nil
+	}
+	r.Found = true
+	r.Codes = append(r.Codes, first)
+	if len(payload) >= 4 {
+		r.Codes = append(r.Codes, binary.LittleEndian.Uint16(payload[2:4]))
+	}
+	return r, nil
+}
+
+// BuildRequestSystemCode builds a Request System Code (0x0C) command
+// frame, used to enumerate every system code a multi-system card holds.
+func BuildRequestSystemCode(idm [8]byte) []byte {
+	return finish(append([]byte{0x00, CmdRequestSystemCode}, idm[:]...))
+}
+
+type RequestSystemCodeResponse struct {
+	IDm         [8]byte
+	SystemCodes []uint16
+}
+
+func ParseRequestSystemCode(f []byte) (*RequestSystemCodeResponse, error) {
+	if err := checkFrame(f, RespRequestSystemCode, 9); err != nil {
+		return nil, err
+	}
+	r := &RequestSystemCodeResponse{}
+	copy(r.IDm[:], f[2:10])
+	n := int(f[10])
+	r.SystemCodes = make([]uint16, 0, n)
+	for i := 0; i < n; i++ {
+		off := 11 + i*2
+		if off+2 > len(f) {
+			return nil, ErrShortFrame
+		}
+		r.SystemCodes = append(r.SystemCodes, binary.BigEndian.Uint16(f[off:off+2]))
+	}
+	return r, nil
+}
@@ -0,0 +1,112 @@
+package frame
+
+import (
+	"reflect"
+	"testing"
+)
+
+// response builds a well-formed response frame (LEN byte + cmd + payload),
+// the same shape finish produces for commands, so tests can hand-construct
+// what a card would actually send back.
+func response(cmd byte, payload ...byte) []byte {
+	return finish(append([]byte{0x00, cmd}, payload...))
+}
+
+func TestBuildPollingRoundTrip(t *testing.T) {
+	cmd := BuildPolling(0xFFFF, 0x01, 0x00)
+	if int(cmd[0]) != len(cmd) {
+		t.Fatalf("LEN byte mismatch: got %d, want %d", cmd[0], len(cmd))
+	}
+	if cmd[1] != CmdPolling {
+		t.Fatalf("command byte = %#02x, want %#02x", cmd[1], CmdPolling)
+	}
+
+	var idm, pmm [8]byte
+	for i := range idm {
+		idm[i] = byte(i + 1)
+		pmm[i] = byte(i + 0x10)
+	}
+	f := response(RespPolling, append(append(append([]byte{}, idm[:]...), pmm[:]...), 0x00, 0x03)...)
+
+	got, err := ParsePolling(f)
+	if err != nil {
+		t.Fatalf("ParsePolling: %v", err)
+	}
+	if got.IDm != idm || got.PMm != pmm || got.SystemCode != 0x0003 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestParsePollingShortFrame(t *testing.T) {
+	if _, err := ParsePolling([]byte{0x03, RespPolling, 0x00}); err != ErrShortFrame {
+		t.Errorf("got %v, want ErrShortFrame", err)
+	}
+}
+
+func TestParseReadWithoutEncryptionStatusOK(t *testing.T) {
+	var idm [8]byte
+	for i := range idm {
+		idm[i] = byte(i + 1)
+	}
+	var block0, block1 [16]byte
+	for i := range block0 {
+		block0[i] = byte(i)
+		block1[i] = byte(0xF0 + i)
+	}
+	payload := append(append([]byte{}, idm[:]...), 0x00, 0x00, 0x02)
+	payload = append(payload, block0[:]...)
+	payload = append(payload, block1[:]...)
+	f := response(RespReadWithoutEncryption, payload...)
+
+	got, err := ParseReadWithoutEncryption(f)
+	if err != nil {
+		t.Fatalf("ParseReadWithoutEncryption: %v", err)
+	}
+	if got.IDm != idm {
+		t.Errorf("IDm = %x, want %x", got.IDm, idm)
+	}
+	want := [][16]byte{block0, block1}
+	if !reflect.DeepEqual(got.Blocks, want) {
+		t.Errorf("Blocks = %x, want %x", got.Blocks, want)
+	}
+}
+
+func TestParseReadWithoutEncryptionNonZeroFlag1(t *testing.T) {
+	var idm [8]byte
+	for i := range idm {
+		idm[i] = byte(i + 1)
+	}
+	f := response(RespReadWithoutEncryption, append(append([]byte{}, idm[:]...), 0xFF, 0xA1)...)
+
+	got, err := ParseReadWithoutEncryption(f)
+	var statusErr *StatusError
+	if err == nil {
+		t.Fatal("expected a StatusError for a non-zero Flag1")
+	}
+	if se, ok := err.(*StatusError); ok {
+		statusErr = se
+	} else {
+		t.Fatalf("err is %T, want *StatusError", err)
+	}
+	if statusErr.Flag1 != 0xFF || statusErr.Flag2 != 0xA1 {
+		t.Errorf("got flag1=%#02x flag2=%#02x, want FF A1", statusErr.Flag1, statusErr.Flag2)
+	}
+	if got == nil || got.IDm != idm {
+		t.Errorf("IDm should still be populated on a status error: got %+v", got)
+	}
+	if len(got.Blocks) != 0 {
+		t.Errorf("Blocks should be empty on a status error: got %x", got.Blocks)
+	}
+}
+
+func TestBlockListElementEncodeBoundary(t *testing.T) {
+	two := BlockListElement{ServiceCodeOrder: 0x01, AccessMode: 0x00, Block: 0xFF}
+	if got, want := two.Encode(), []byte{0x81, 0xFF}; !reflect.DeepEqual(got, want) {
+		t.Errorf("2-byte form: got % x, want % x", got, want)
+	}
+
+	three := BlockListElement{ServiceCodeOrder: 0x01, AccessMode: 0x00, Block: 0x100}
+	if got, want := three.Encode(), []byte{0x01, 0x00, 0x01}; !reflect.DeepEqual(got, want) {
+		t.Errorf("3-byte form: got % x, want % x", got, want)
+	}
+}
@@ -0,0 +1,304 @@
+package felica
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrUnknownCKV = errors.New("unknown ckv")
+
+// KeyStore looks up, tracks, and rotates the master keys a deployment
+// provisions its FeliCa Lite-S cards with, indexed by CKV. Lookup can
+// back a MasterKeyProvider, but it is not one itself: its shape differs
+// by the error return, so wrap it with a closure where a plain
+// MasterKeyProvider is required.
+type KeyStore interface {
+	// Lookup returns the master key registered for ckv, or ErrUnknownCKV.
+	Lookup(ckv [2]byte) (*[24]byte, error)
+	// Current returns the CKV and master key new cards should be
+	// provisioned or rotated to.
+	Current() (ckv [2]byte, key *[24]byte)
+	// Rotate makes newCKV the result of Current, while leaving oldCKV
+	// (and newCKV) both valid for Lookup. This dual-validity window is
+	// what makes RotateKey recoverable: if a rotation is interrupted
+	// between writing the new CKV and this call, the card still
+	// authenticates against whichever of the two keys it ended up with.
+	Rotate(oldCKV, newCKV [2]byte)
+}
+
+// MapKeyStore is a KeyStore backed by an in-memory map, suitable for a
+// single process managing its own key material.
+type MapKeyStore struct {
+	mu      sync.RWMutex
+	keys    map[[2]byte]*[24]byte
+	current [2]byte
+}
+
+// NewMapKeyStore creates a MapKeyStore whose only, current key is
+// (ckv, key).
+func NewMapKeyStore(ckv [2]byte, key *[24]byte) *MapKeyStore {
+	return &MapKeyStore{
+		keys:    map[[2]byte]*[24]byte{ckv: key},
+		current: ckv,
+	}
+}
+
+// Add registers key under ckv without changing Current. Call it to
+// provision a new key before rotating to it.
+func (s *MapKeyStore) Add(ckv [2]byte, key *[24]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[ckv] = key
+}
+
+func (s *MapKeyStore) Lookup(ckv [2]byte) (*[24]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[ckv]
+	if !ok {
+		return nil, ErrUnknownCKV
+	}
+	return key, nil
+}
+
+func (s *MapKeyStore) Current() (ckv [2]byte, key *[24]byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current, s.keys[s.current]
+}
+
+// Rotate is a no-op if newCKV was never registered via Add: an
+// unregistered CKV would make Current return a nil key, and a nil key
+// reaching GenCardKey panics deep in key derivation rather than failing
+// cleanly here.
+func (s *MapKeyStore) Rotate(oldCKV, newCKV [2]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[newCKV]; !ok {
+		return
+	}
+	s.current = newCKV
+}
+
+// RotateKey migrates c from its current CK/CKV to newCKV/newMasterKey
+// while still authenticated under the old key. It writes the new CK then
+// the new CKV - each authenticated by the session key established at the
+// start of this tap, the same way WriteWithMac protects any other block
+// - then runs a fresh RC/MAC challenge under the new CK (derived from
+// newMasterKey before either write) to confirm the card actually
+// accepted it. If that
+// verification fails, it writes the old CK/CKV back rather than leaving
+// the card on a key nobody has confirmed works.
+//
+// Mirroring the SPI-style dual-index approach, both CK values are briefly
+// valid on the card (the old one until its CKV is overwritten, the new
+// one as soon as it is written): a rotation interrupted by power loss or
+// the card leaving the field is recoverable on the next tap by looking
+// up whichever CKV the card reports via a KeyStore that still has both
+// registered (see KeyStore.Rotate).
+func (c *FeliCaLiteS) RotateKey(newCKV [2]byte, newMasterKey *[24]byte) error {
+	return c.RotateKeyContext(context.Background(), newCKV, newMasterKey)
+}
+
+func (c *FeliCaLiteS) RotateKeyContext(ctx context.Context, newCKV [2]byte, newMasterKey *[24]byte) error {
+	oldCK := c.CK
+
+	ckvBlocks, err := c.ReadWithMacContext(ctx, []byte{AddressCKV})
+	if err != nil {
+		return fmt.Errorf("failed to read current CKV: %w", err)
+	}
+	oldCKVData := ckvBlocks[0].Data
+
+	newCK := deriveCardKey(c.ID, newMasterKey)
+
+	if err := c.WriteWithMacContext(ctx, Block{Address: AddressCK, Data: newCK}); err != nil {
+		return fmt.Errorf("failed to write new CK: %w", err)
+	}
+
+	newCKVData := oldCKVData
+	newCKVData[0], newCKVData[1] = newCKV[0], newCKV[1]
+	if err := c.WriteWithMacContext(ctx, Block{Address: AddressCKV, Data: newCKVData}); err != nil {
+		if rbErr := c.WriteWithMacContext(ctx, Block{Address: AddressCK, Data: oldCK}); rbErr != nil {
+			return fmt.Errorf("failed to write new CKV (%v), and failed to roll back CK: %w", err, rbErr)
+		}
+		return fmt.Errorf("failed to write new CKV, rolled back CK: %w", err)
+	}
+
+	// rollback restores oldCK/oldCKVData using session, the authenticated
+	// FeliCaLiteS that actually matches what the card holds right now.
+	// That is NOT necessarily c: reauthenticate's first step writes a
+	// fresh RC, which starts a brand new authentication round on the card
+	// keyed off whatever CK is really stored there (newCK, since the
+	// write above already succeeded) - so c's old SK/RC stop matching the
+	// live card the moment that RC lands, even though c itself hasn't
+	// been mutated yet.
+	rollback := func(session *FeliCaLiteS, cause error) error {
+		if err := session.WriteWithMacContext(ctx, Block{Address: AddressCKV, Data: oldCKVData}); err != nil {
+			return fmt.Errorf("%w (rollback of CKV also failed: %v)", cause, err)
+		}
+		if err := session.WriteWithMacContext(ctx, Block{Address: AddressCK, Data: oldCK}); err != nil {
+			return fmt.Errorf("%w (rollback of CK also failed: %v)", cause, err)
+		}
+		return cause
+	}
+
+	verify, err := c.reauthenticate(ctx, newCK)
+	if err != nil {
+		// If reauthenticate never got as far as writing that fresh RC,
+		// verify is nil and the card's session is still the one c holds;
+		// otherwise verify is the only session left that can still talk
+		// to the card.
+		session := verify
+		if session == nil {
+			session = c
+		}
+		return rollback(session, fmt.Errorf("failed to verify new key: %w", err))
+	}
+
+	c.CK = newCK
+	c.SK = verify.SK
+	c.RC = verify.RC
+	return nil
+}
+
+// reauthenticate runs a fresh RC/MAC challenge - the same shape as
+// NewFelicaCard's handshake - against the key ck is expected to be, and
+// returns the FeliCaLiteS whose RC/SK matched it.
+//
+// Writing that fresh RC is itself what starts the new authentication
+// round on the card, so once it succeeds the card's live session is
+// verify's, whether or not the MAC comparison that follows passes: a
+// mismatch there means ck was the wrong assumption, not that the RC
+// write didn't happen. For that reason verify is returned alongside
+// ErrMacNotMatched too, so a caller doing rollback can keep talking to
+// the card through it instead of through a session the RC write just
+// invalidated. Only a failure before that RC write leaves the card's
+// session, and so the caller's own, untouched.
+func (c *FeliCaLiteS) reauthenticate(ctx context.Context, ck [16]byte) (*FeliCaLiteS, error) {
+	verify := &FeliCaLiteS{Transport: c.Transport, RetryBackoff: c.RetryBackoff, ID: c.ID, CK: ck}
+
+	if _, err := rand.Read(verify.RC[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate verification RC: %w", err)
+	}
+	if err := verify.WriteContext(ctx, []Block{{Address: AddressRC, Data: verify.RC}}); err != nil {
+		return nil, fmt.Errorf("failed to write verification RC: %w", err)
+	}
+	// SK is fully determined by ck and verify.RC, both already fixed at
+	// this point - derive it now rather than after the read below, so
+	// verify's SK is valid for a rollback write even if that read fails.
+	verify.GenSessionKey()
+
+	resp, err := verify.ReadContext(ctx, []byte{AddressCKV, AddressMAC_A})
+	if err != nil {
+		return verify, fmt.Errorf("failed verification read: %w", err)
+	}
+
+	mac := verify.GenReadMac(resp)
+	if mac != [8]byte(resp[1].Data[:8]) {
+		return verify, ErrMacNotMatched
+	}
+	return verify, nil
+}
+
+// LockReadOnly permanently disables both Write and WriteWithMac on c by
+// clearing the corresponding bits of the MC (Memory Configuration) block
+// (AddressMC). Like the equivalent operation in real FeliCa Lite-S
+// hardware, this is a one-way trip: once those bits are cleared, writing
+// them back would itself require a write.
+func (c *FeliCaLiteS) LockReadOnly() error {
+	return c.LockReadOnlyContext(context.Background())
+}
+
+// MC byte offsets, per the FeliCa Lite-S User's Manual's Memory
+// Configuration block table: bytes 0-3 are RFU, byte 4 is WriteF (plain
+// Write permission for S_PAD0-13/REG), byte 5 is WriteFMAC (WriteWithMac
+// permission for the same blocks), byte 8 onward covers RF parameters
+// and the (unrelated) STOP kill-switch bit.
+const (
+	// mcWriteWithoutMACBit is bit 0 of MC byte 4 (WriteF): 1 enables
+	// plain Write, 0 disables it.
+	mcWriteWithoutMACBit = 4
+	// mcWriteWithMACBit is bit 0 of MC byte 5 (WriteFMAC): 1 enables
+	// WriteWithMac, 0 disables it.
+	mcWriteWithMACBit = 5
+)
+
+func (c *FeliCaLiteS) LockReadOnlyContext(ctx context.Context) error {
+	resp, err := c.ReadWithMacContext(ctx, []byte{AddressMC})
+	if err != nil {
+		return fmt.Errorf("failed to read MC: %w", err)
+	}
+	mc := resp[0].Data
+	mc[mcWriteWithoutMACBit] &^= 0x01
+	mc[mcWriteWithMACBit] &^= 0x01
+	// This is necessarily the last write this card will ever authorize
+	// for itself, so it must go through WriteWithMac (AddressMC is MAC
+	// protected) before mcWriteWithMACBit takes effect.
+	return c.WriteWithMacContext(ctx, Block{Address: AddressMC, Data: mc})
+}
+
+// DiversifyKey derives a per-card 24-byte 3DES key from systemKey and a
+// card's ID using CMAC-3DES (NIST SP 800-38B with Triple-DES as the
+// block cipher), run twice to get two independent 8-byte halves. Use this instead
+// of a per-card KeyStore entry when cards share one system key rather
+// than being issued individual ones: derive the master key once the
+// card's ID is known (e.g. from FeliCaLiteS.ID after an unauthenticated
+// NewFelicaCard call, or felica_pcsc.GetData with DataTypeID) and feed it
+// to GenCardKey as usual.
+func DiversifyKey(systemKey *[24]byte, id [16]byte) (*[24]byte, error) {
+	cipher, err := des.NewTripleDESCipher(systemKey[:])
+	if err != nil {
+		return nil, err
+	}
+	m1 := ([8]byte)(id[:8])
+	m2 := ([8]byte)(id[8:])
+
+	t1 := cmacTwoBlocks(cipher, m1, m2)
+	m1[0] ^= 0x01 // vary the message so the second half differs from the first
+	t2 := cmacTwoBlocks(cipher, m1, m2)
+
+	var key [24]byte
+	copy(key[0:8], t1[:])
+	copy(key[8:16], t2[:])
+	copy(key[16:24], t1[:]) // 2-key 3DES (K1, K2, K1), as systemKey itself may be
+	return &key, nil
+}
+
+// cmacTwoBlocks computes the NIST SP 800-38B CMAC of the 16-byte message
+// m1||m2 under cipher, which is always exactly two complete blocks so the
+// full variable-length CMAC padding machinery isn't needed.
+func cmacTwoBlocks(cipher cipher.Block, m1, m2 [8]byte) [8]byte {
+	var k1 [8]byte
+	cipher.Encrypt(k1[:], make([]byte, 8))
+	k1 = dbl(k1)
+
+	var c1 [8]byte
+	cipher.Encrypt(c1[:], m1[:])
+
+	final := m2
+	xor(final[:], k1[:])
+	xor(final[:], c1[:])
+
+	var tag [8]byte
+	cipher.Encrypt(tag[:], final[:])
+	return tag
+}
+
+// dbl is the CMAC subkey-generation doubling operation over GF(2^64).
+func dbl(b [8]byte) [8]byte {
+	msb := b[0] & 0x80
+	var out [8]byte
+	for i := 0; i < 7; i++ {
+		out[i] = b[i]<<1 | b[i+1]>>7
+	}
+	out[7] = b[7] << 1
+	if msb != 0 {
+		out[7] ^= 0x1b
+	}
+	return out
+}
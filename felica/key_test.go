@@ -0,0 +1,221 @@
+package felica
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+)
+
+// fakeLiteSCard is a minimal in-memory stand-in for a FeliCa Lite-S card's
+// PC/SC pseudo-APDU surface (0xFF B0/D6/A4), just enough of it to drive
+// RotateKey and LockReadOnly through Transport. It mirrors one subtlety of
+// the real hardware that these tests depend on: the session key is derived
+// once, from whatever CK is stored when RC is (re)written, and keeps
+// working against later CK/CKV/MC writes in the same session even though
+// the stored CK itself has since changed - it only gets re-derived on the
+// next RC write. It does not verify incoming write MACs; it trusts the
+// caller the way the read-before-write WCNT exchange already does.
+type fakeLiteSCard struct {
+	id  [16]byte
+	ck  [16]byte
+	ckv [16]byte
+	mc  [16]byte
+
+	sessionCK [16]byte
+	rc        [16]byte
+	wcnt      [3]byte
+
+	// corruptNextVerifyMAC arms a corrupted MAC for the first MAC_A read
+	// after the next RC write, i.e. the read that verifies a freshly
+	// started authentication round; reads before that RC write are left
+	// intact.
+	corruptNextVerifyMAC bool
+	verifyMACArmed       bool
+}
+
+func newFakeLiteSCard(id, ck [16]byte, ckv [2]byte) *fakeLiteSCard {
+	f := &fakeLiteSCard{id: id, ck: ck}
+	f.ckv[0], f.ckv[1] = ckv[0], ckv[1]
+	return f
+}
+
+func (f *fakeLiteSCard) Transmit(apdu []byte) ([]byte, error) {
+	switch {
+	case len(apdu) >= 2 && apdu[0] == 0xFF && apdu[1] == 0xA4:
+		return []byte{0x90, 0x00}, nil
+	case len(apdu) >= 2 && apdu[0] == 0xFF && apdu[1] == 0xB0:
+		return f.read(apdu), nil
+	case len(apdu) >= 2 && apdu[0] == 0xFF && apdu[1] == 0xD6:
+		return f.write(apdu), nil
+	default:
+		return []byte{0x64, 0x01}, nil
+	}
+}
+
+func (f *fakeLiteSCard) addresses(apdu []byte) []byte {
+	count := int(apdu[3])
+	blockList := apdu[5 : 5+count*2]
+	addrs := make([]byte, count)
+	for i := range addrs {
+		addrs[i] = blockList[i*2+1]
+	}
+	return addrs
+}
+
+func (f *fakeLiteSCard) read(apdu []byte) []byte {
+	addrs := f.addresses(apdu)
+	blocks := make([]Block, len(addrs))
+	for i, addr := range addrs {
+		var data [16]byte
+		switch addr {
+		case AddressID:
+			data = f.id
+		case AddressCKV:
+			data = f.ckv
+		case AddressMC:
+			data = f.mc
+		case AddressWCNT:
+			copy(data[:3], f.wcnt[:])
+		}
+		blocks[i] = Block{Address: addr, Data: data}
+	}
+
+	if n := len(blocks); n > 0 && blocks[n-1].Address == AddressMAC_A {
+		session := &FeliCaLiteS{CK: f.sessionCK, RC: f.rc}
+		session.GenSessionKey()
+		mac := session.GenReadMac(blocks)
+		var macData [16]byte
+		copy(macData[:8], mac[:])
+		if f.verifyMACArmed {
+			macData[0] ^= 0xFF
+			f.verifyMACArmed = false
+		}
+		blocks[n-1].Data = macData
+	}
+
+	resp := make([]byte, 0, len(blocks)*16+2)
+	for _, b := range blocks {
+		resp = append(resp, b.Data[:]...)
+	}
+	return append(resp, 0x90, 0x00)
+}
+
+func (f *fakeLiteSCard) write(apdu []byte) []byte {
+	addrs := f.addresses(apdu)
+	dataStart := 5 + len(addrs)*2
+	for i, addr := range addrs {
+		var data [16]byte
+		copy(data[:], apdu[dataStart+i*16:dataStart+(i+1)*16])
+		switch addr {
+		case AddressRC:
+			// Writing RC starts a fresh authentication round: the
+			// session key this tap will use from now on is derived from
+			// whatever CK is live at this moment, not whatever it is
+			// later overwritten to.
+			f.sessionCK = f.ck
+			f.rc = data
+			if f.corruptNextVerifyMAC {
+				f.verifyMACArmed = true
+				f.corruptNextVerifyMAC = false
+			}
+		case AddressCK:
+			f.ck = data
+		case AddressCKV:
+			f.ckv = data
+		case AddressMC:
+			f.mc = data
+		}
+	}
+	f.wcnt[2]++
+	return []byte{0x90, 0x00}
+}
+
+func TestRotateKeyRollsBackOnVerifyFailure(t *testing.T) {
+	id := [16]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	oldMasterKey := [24]byte{}
+	newMasterKey := [24]byte{}
+	for i := range oldMasterKey {
+		oldMasterKey[i] = 0xAA
+		newMasterKey[i] = 0xBB
+	}
+
+	oldCK := deriveCardKey(id, &oldMasterKey)
+	card := newFakeLiteSCard(id, oldCK, [2]byte{0x00, 0x01})
+	oldCKV := card.ckv
+
+	c := &FeliCaLiteS{Transport: card, ID: id, CK: oldCK}
+	if _, err := rand.Read(c.RC[:]); err != nil {
+		t.Fatalf("failed to generate initial RC: %v", err)
+	}
+	if err := c.WriteContext(context.Background(), []Block{{Address: AddressRC, Data: c.RC}}); err != nil {
+		t.Fatalf("initial RC write: %v", err)
+	}
+	c.GenSessionKey()
+
+	card.corruptNextVerifyMAC = true
+
+	if err := c.RotateKey([2]byte{0x00, 0x02}, &newMasterKey); err == nil {
+		t.Fatal("expected RotateKey to fail when the verification MAC is corrupted")
+	}
+
+	if card.ck != oldCK {
+		t.Errorf("card CK was not rolled back: got %x, want %x", card.ck, oldCK)
+	}
+	if card.ckv != oldCKV {
+		t.Errorf("card CKV was not rolled back: got %x, want %x", card.ckv, oldCKV)
+	}
+}
+
+func TestLockReadOnlyClearsWriteFlagBits(t *testing.T) {
+	id := [16]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	masterKey := [24]byte{}
+	for i := range masterKey {
+		masterKey[i] = 0xCC
+	}
+	ck := deriveCardKey(id, &masterKey)
+
+	card := newFakeLiteSCard(id, ck, [2]byte{0x00, 0x01})
+	for i := range card.mc {
+		card.mc[i] = 0xFF
+	}
+
+	c := &FeliCaLiteS{Transport: card, ID: id, CK: ck}
+	if _, err := rand.Read(c.RC[:]); err != nil {
+		t.Fatalf("failed to generate RC: %v", err)
+	}
+	if err := c.WriteContext(context.Background(), []Block{{Address: AddressRC, Data: c.RC}}); err != nil {
+		t.Fatalf("initial RC write: %v", err)
+	}
+	c.GenSessionKey()
+
+	if err := c.LockReadOnly(); err != nil {
+		t.Fatalf("LockReadOnly: %v", err)
+	}
+
+	if card.mc[4]&0x01 != 0 {
+		t.Errorf("MC byte 4 (WriteF) bit 0 not cleared: got %#02x", card.mc[4])
+	}
+	if card.mc[5]&0x01 != 0 {
+		t.Errorf("MC byte 5 (WriteFMAC) bit 0 not cleared: got %#02x", card.mc[5])
+	}
+	for i, b := range card.mc {
+		if i == 4 || i == 5 {
+			continue
+		}
+		if b != 0xFF {
+			t.Errorf("MC byte %d unexpectedly modified: got %#02x", i, b)
+		}
+	}
+}
+
+func TestMapKeyStoreRotateRejectsUnregisteredCKV(t *testing.T) {
+	key := &[24]byte{}
+	s := NewMapKeyStore([2]byte{0x00, 0x01}, key)
+
+	s.Rotate([2]byte{0x00, 0x01}, [2]byte{0x00, 0x02})
+
+	ckv, got := s.Current()
+	if ckv != ([2]byte{0x00, 0x01}) || got != key {
+		t.Errorf("Current changed after rotating to an unregistered CKV: got (%x, %p), want (%x, %p)", ckv, got, [2]byte{0x00, 0x01}, key)
+	}
+}